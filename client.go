@@ -0,0 +1,98 @@
+package s3backend
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	cache "flamingo.me/flamingo/v3/core/cache"
+	"flamingo.me/flamingo/v3/framework/flamingo"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+)
+
+const (
+	// bucketExistsPollInterval is the delay between HeadBucket polls in waitUntilBucketExists
+	bucketExistsPollInterval = 500 * time.Millisecond
+	// bucketExistsMaxAttempts bounds how long NewS3BackendWithClient waits for bucket creation to propagate
+	bucketExistsMaxAttempts = 20
+)
+
+// S3API covers just the S3 calls S3Backend makes, so a caller can supply a fake for tests or an
+// aws-sdk-go-v2-backed adapter instead of the default *s3.S3 client without touching backend logic.
+// *s3.S3 satisfies this interface as-is.
+type S3API interface {
+	GetObject(*s3.GetObjectInput) (*s3.GetObjectOutput, error)
+	PutObject(*s3.PutObjectInput) (*s3.PutObjectOutput, error)
+	DeleteObject(*s3.DeleteObjectInput) (*s3.DeleteObjectOutput, error)
+	DeleteObjects(*s3.DeleteObjectsInput) (*s3.DeleteObjectsOutput, error)
+	ListObjectsV2Pages(*s3.ListObjectsV2Input, func(*s3.ListObjectsV2Output, bool) bool) error
+	HeadBucket(*s3.HeadBucketInput) (*s3.HeadBucketOutput, error)
+	CreateBucket(*s3.CreateBucketInput) (*s3.CreateBucketOutput, error)
+	GetBucketLifecycleConfiguration(*s3.GetBucketLifecycleConfigurationInput) (*s3.GetBucketLifecycleConfigurationOutput, error)
+	PutBucketLifecycleConfiguration(*s3.PutBucketLifecycleConfigurationInput) (*s3.PutBucketLifecycleConfigurationOutput, error)
+}
+
+type (
+	// Uploader covers the s3manager.Uploader method SetStream and the multipart Set path need
+	Uploader interface {
+		Upload(*s3manager.UploadInput, ...func(*s3manager.Uploader)) (*s3manager.UploadOutput, error)
+	}
+
+	// Downloader covers the s3manager.Downloader method GetStream could use for concurrent range downloads
+	Downloader interface {
+		Download(io.WriterAt, *s3.GetObjectInput, ...func(*s3manager.Downloader)) (int64, error)
+	}
+)
+
+// NewS3BackendWithClient creates an S3Backend around a caller-supplied S3API, instead of building
+// one from an aws.Config/session internally. This is the extension point for injecting test fakes
+// or a client backed by a different SDK generation (e.g. aws-sdk-go-v2); uploader/downloader are
+// supplied separately since they are built from a concrete client, not from the narrow S3API.
+func NewS3BackendWithClient(client S3API, uploader Uploader, downloader Downloader, keyPrefix string, bucketName string, frontendName string, opts ...Option) *S3Backend {
+	_, _ = client.CreateBucket(&s3.CreateBucketInput{
+		Bucket: aws.String(bucketName),
+	})
+
+	if err := waitUntilBucketExists(client, bucketName); err != nil {
+		panic(err)
+	}
+
+	b := &S3Backend{
+		cacheMetrics:        cache.NewCacheMetrics("s3", frontendName),
+		keyPrefix:           keyPrefix,
+		bucketName:          bucketName,
+		logger:              flamingo.NullLogger{},
+		s3:                  client,
+		uploader:            uploader,
+		downloader:          downloader,
+		codec:               GobCodec{},
+		multipartThreshold:  defaultMultipartThreshold,
+		lifecycleMaxAgeDays: defaultLifecycleMaxAgeDays,
+	}
+
+	for _, opt := range opts {
+		opt(b)
+	}
+
+	if err := b.configureLifecycle(); err != nil {
+		b.logger.WithField("category", "s3Backend").Error(fmt.Sprintf("Failed to configure bucket lifecycle: %v", err))
+	}
+
+	return b
+}
+
+// waitUntilBucketExists polls HeadBucket until it succeeds or bucketExistsMaxAttempts is reached.
+// It replaces the SDK-generated *s3.S3.WaitUntilBucketExists waiter, which isn't part of S3API.
+func waitUntilBucketExists(client S3API, bucketName string) error {
+	var err error
+	for attempt := 0; attempt < bucketExistsMaxAttempts; attempt++ {
+		_, err = client.HeadBucket(&s3.HeadBucketInput{Bucket: aws.String(bucketName)})
+		if err == nil {
+			return nil
+		}
+		time.Sleep(bucketExistsPollInterval)
+	}
+	return err
+}
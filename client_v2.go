@@ -0,0 +1,143 @@
+//go:build s3v2
+// +build s3v2
+
+package s3backend
+
+import (
+	"context"
+
+	s3v2 "github.com/aws/aws-sdk-go-v2/service/s3"
+	s3v2types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// s3v2Client adapts an aws-sdk-go-v2 s3.Client to the S3API interface, so S3Backend can run on
+// either SDK generation via NewS3BackendWithClient. Build with -tags s3v2 to pull this file in.
+type s3v2Client struct {
+	client *s3v2.Client
+}
+
+// NewS3V2Client wraps an aws-sdk-go-v2 s3.Client as an S3API
+func NewS3V2Client(client *s3v2.Client) S3API {
+	return &s3v2Client{client: client}
+}
+
+func (c *s3v2Client) GetObject(in *s3.GetObjectInput) (*s3.GetObjectOutput, error) {
+	out, err := c.client.GetObject(context.Background(), &s3v2.GetObjectInput{
+		Bucket:               in.Bucket,
+		Key:                  in.Key,
+		SSECustomerAlgorithm: in.SSECustomerAlgorithm,
+		SSECustomerKey:       in.SSECustomerKey,
+		SSECustomerKeyMD5:    in.SSECustomerKeyMD5,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &s3.GetObjectOutput{
+		Body:         out.Body,
+		ContentType:  out.ContentType,
+		LastModified: out.LastModified,
+		Metadata:     aws.StringMap(out.Metadata),
+	}, nil
+}
+
+func (c *s3v2Client) PutObject(in *s3.PutObjectInput) (*s3.PutObjectOutput, error) {
+	_, err := c.client.PutObject(context.Background(), &s3v2.PutObjectInput{
+		Bucket:               in.Bucket,
+		Key:                  in.Key,
+		Body:                 in.Body,
+		ContentType:          in.ContentType,
+		Metadata:             aws.StringValueMap(in.Metadata),
+		Expires:              in.Expires,
+		ServerSideEncryption: v2ServerSideEncryption(in.ServerSideEncryption),
+		SSEKMSKeyId:          in.SSEKMSKeyId,
+		SSECustomerAlgorithm: in.SSECustomerAlgorithm,
+		SSECustomerKey:       in.SSECustomerKey,
+		SSECustomerKeyMD5:    in.SSECustomerKeyMD5,
+	})
+	return &s3.PutObjectOutput{}, err
+}
+
+// v2ServerSideEncryption translates the v1 SDK's ServerSideEncryption string pointer (e.g. "AES256",
+// "aws:kms") to the v2 SDK's enum type. PutObject previously dropped this field (and SSEKMSKeyId)
+// entirely, so a backend configured for EncryptionSSES3/EncryptionSSEKMS over the v2 client silently
+// wrote unencrypted objects.
+func v2ServerSideEncryption(sse *string) s3v2types.ServerSideEncryption {
+	if sse == nil {
+		return ""
+	}
+	return s3v2types.ServerSideEncryption(aws.StringValue(sse))
+}
+
+func (c *s3v2Client) DeleteObject(in *s3.DeleteObjectInput) (*s3.DeleteObjectOutput, error) {
+	_, err := c.client.DeleteObject(context.Background(), &s3v2.DeleteObjectInput{
+		Bucket: in.Bucket,
+		Key:    in.Key,
+	})
+	return &s3.DeleteObjectOutput{}, err
+}
+
+func (c *s3v2Client) DeleteObjects(in *s3.DeleteObjectsInput) (*s3.DeleteObjectsOutput, error) {
+	objects := make([]s3v2types.ObjectIdentifier, 0, len(in.Delete.Objects))
+	for _, o := range in.Delete.Objects {
+		objects = append(objects, s3v2types.ObjectIdentifier{Key: o.Key})
+	}
+
+	_, err := c.client.DeleteObjects(context.Background(), &s3v2.DeleteObjectsInput{
+		Bucket: in.Bucket,
+		Delete: &s3v2types.Delete{Objects: objects},
+	})
+	return &s3.DeleteObjectsOutput{}, err
+}
+
+func (c *s3v2Client) ListObjectsV2Pages(in *s3.ListObjectsV2Input, fn func(*s3.ListObjectsV2Output, bool) bool) error {
+	paginator := s3v2.NewListObjectsV2Paginator(c.client, &s3v2.ListObjectsV2Input{
+		Bucket: in.Bucket,
+		Prefix: in.Prefix,
+	})
+
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(context.Background())
+		if err != nil {
+			return err
+		}
+
+		contents := make([]*s3.Object, 0, len(page.Contents))
+		for _, o := range page.Contents {
+			contents = append(contents, &s3.Object{Key: o.Key})
+		}
+
+		if !fn(&s3.ListObjectsV2Output{Contents: contents}, !paginator.HasMorePages()) {
+			return nil
+		}
+	}
+
+	return nil
+}
+
+func (c *s3v2Client) HeadBucket(in *s3.HeadBucketInput) (*s3.HeadBucketOutput, error) {
+	_, err := c.client.HeadBucket(context.Background(), &s3v2.HeadBucketInput{Bucket: in.Bucket})
+	return &s3.HeadBucketOutput{}, err
+}
+
+func (c *s3v2Client) CreateBucket(in *s3.CreateBucketInput) (*s3.CreateBucketOutput, error) {
+	_, err := c.client.CreateBucket(context.Background(), &s3v2.CreateBucketInput{Bucket: in.Bucket})
+	return &s3.CreateBucketOutput{}, err
+}
+
+func (c *s3v2Client) GetBucketLifecycleConfiguration(in *s3.GetBucketLifecycleConfigurationInput) (*s3.GetBucketLifecycleConfigurationOutput, error) {
+	// Mirrors PutBucketLifecycleConfiguration below: lifecycle rule translation is out of scope for
+	// the v2 adapter for now, so report "nothing configured" rather than guessing at a translation.
+	// configureLifecycle treats this error code as "nothing to merge with" and proceeds.
+	return nil, awserr.New("NoSuchLifecycleConfiguration", "lifecycle configuration translation not supported by the v2 adapter", nil)
+}
+
+func (c *s3v2Client) PutBucketLifecycleConfiguration(in *s3.PutBucketLifecycleConfigurationInput) (*s3.PutBucketLifecycleConfigurationOutput, error) {
+	// Lifecycle rule translation is intentionally left out of the v2 adapter for now: the read/
+	// write/delete path is what NewS3BackendWithClient callers need for migration and testing, and
+	// NewS3Backend-managed lifecycle provisioning keeps working unchanged on the v1 client.
+	return &s3.PutBucketLifecycleConfigurationOutput{}, nil
+}
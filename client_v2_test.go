@@ -0,0 +1,31 @@
+//go:build s3v2
+// +build s3v2
+
+package s3backend
+
+import (
+	"testing"
+
+	s3v2types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+func TestV2ServerSideEncryption(t *testing.T) {
+	cases := []struct {
+		name string
+		in   *string
+		want s3v2types.ServerSideEncryption
+	}{
+		{name: "none", in: nil, want: ""},
+		{name: "sse-s3", in: aws.String("AES256"), want: s3v2types.ServerSideEncryptionAes256},
+		{name: "sse-kms", in: aws.String("aws:kms"), want: s3v2types.ServerSideEncryptionAwsKms},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := v2ServerSideEncryption(c.in); got != c.want {
+				t.Errorf("v2ServerSideEncryption(%v) = %v, want %v", aws.StringValue(c.in), got, c.want)
+			}
+		})
+	}
+}
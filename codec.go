@@ -0,0 +1,126 @@
+package s3backend
+
+import (
+	"encoding/gob"
+	"encoding/json"
+	"io"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// metaKeyCodec is the S3 user-metadata key holding the Codec.Name() that wrote an object, i.e.
+// "x-amz-meta-codec" on the wire.
+const metaKeyCodec = "Codec"
+
+// Codec encodes and decodes an S3CacheEntry to/from the S3 object body. It is identified by Name,
+// which is stored as the x-amz-meta-codec object metadata so Get can pick the matching Codec back
+// up regardless of which one wrote the entry.
+//
+// S3CacheEntry.Data is interface{}, so Decode's ability to hand back the exact concrete type Encode
+// was given depends on the wire format: see GobCodec vs JSONCodec/MsgpackCodec below.
+type Codec interface {
+	// Name returns the codec identifier stored in x-amz-meta-codec
+	Name() string
+	// ContentType returns the MIME type stored as the object's Content-Type
+	ContentType() string
+	Encode(w io.Writer, entry *S3CacheEntry) error
+	Decode(r io.Reader) (*S3CacheEntry, error)
+}
+
+type (
+	// GobCodec encodes entries with encoding/gob, the backend's original wire format. gob carries
+	// concrete type information on the wire, so Decode returns Data as the same concrete type Encode
+	// was given. init in s3Backend.go registers only S3CacheEntry; each concrete Data type that is not
+	// a gob builtin must be registered with gob.Register by the caller, or Encode fails.
+	GobCodec struct{}
+
+	// JSONCodec encodes entries with encoding/json. JSON has no concrete-type information on the
+	// wire, so Decode cannot reconstruct Data's original concrete type: it comes back as one of
+	// json.Unmarshal's generic interface{} representations (map[string]interface{}, []interface{},
+	// float64, etc.) rather than, say, the original struct or int. Only use JSONCodec when callers
+	// either don't care about Data's concrete type or re-decode it themselves (e.g. via a type switch
+	// or a second json.Unmarshal into a known struct).
+	JSONCodec struct{}
+
+	// MsgpackCodec encodes entries with msgpack, a compact binary alternative to JSON. Like
+	// JSONCodec, msgpack carries no concrete-type information on the wire, so Decode has the same
+	// type-loss limitation: Data comes back as msgpack's generic interface{} representation, not the
+	// original concrete type.
+	MsgpackCodec struct{}
+)
+
+// codecsByName resolves the x-amz-meta-codec metadata value on Get to the Codec that wrote it
+var codecsByName = map[string]Codec{
+	GobCodec{}.Name():     GobCodec{},
+	JSONCodec{}.Name():    JSONCodec{},
+	MsgpackCodec{}.Name(): MsgpackCodec{},
+}
+
+// resolveCodec looks up a codec by its x-amz-meta-codec name, falling back to GobCodec for entries
+// written before the codec metadata existed
+func resolveCodec(name string) Codec {
+	if codec, ok := codecsByName[name]; ok {
+		return codec
+	}
+	return GobCodec{}
+}
+
+// Name implements Codec
+func (GobCodec) Name() string { return "gob" }
+
+// ContentType implements Codec
+func (GobCodec) ContentType() string { return "application/octet-stream" }
+
+// Encode implements Codec
+func (GobCodec) Encode(w io.Writer, entry *S3CacheEntry) error {
+	return gob.NewEncoder(w).Encode(entry)
+}
+
+// Decode implements Codec
+func (GobCodec) Decode(r io.Reader) (*S3CacheEntry, error) {
+	entry := new(S3CacheEntry)
+	if err := gob.NewDecoder(r).Decode(entry); err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+// Name implements Codec
+func (JSONCodec) Name() string { return "json" }
+
+// ContentType implements Codec
+func (JSONCodec) ContentType() string { return "application/json" }
+
+// Encode implements Codec
+func (JSONCodec) Encode(w io.Writer, entry *S3CacheEntry) error {
+	return json.NewEncoder(w).Encode(entry)
+}
+
+// Decode implements Codec
+func (JSONCodec) Decode(r io.Reader) (*S3CacheEntry, error) {
+	entry := new(S3CacheEntry)
+	if err := json.NewDecoder(r).Decode(entry); err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+// Name implements Codec
+func (MsgpackCodec) Name() string { return "msgpack" }
+
+// ContentType implements Codec
+func (MsgpackCodec) ContentType() string { return "application/msgpack" }
+
+// Encode implements Codec
+func (MsgpackCodec) Encode(w io.Writer, entry *S3CacheEntry) error {
+	return msgpack.NewEncoder(w).Encode(entry)
+}
+
+// Decode implements Codec
+func (MsgpackCodec) Decode(r io.Reader) (*S3CacheEntry, error) {
+	entry := new(S3CacheEntry)
+	if err := msgpack.NewDecoder(r).Decode(entry); err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
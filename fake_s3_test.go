@@ -0,0 +1,165 @@
+package s3backend
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+)
+
+// fakeObject is the in-memory representation of an object stored by fakeS3.
+type fakeObject struct {
+	body         []byte
+	metadata     map[string]*string
+	lastModified time.Time
+}
+
+// fakeS3 is a minimal in-memory S3API, enough to exercise S3Backend in unit tests without a MinIO
+// container. fakeUploader and fakeDownloader below wrap it as the Uploader/Downloader.
+type fakeS3 struct {
+	mu             sync.Mutex
+	objects        map[string]*fakeObject
+	lifecycleRules []*s3.LifecycleRule
+	getCalls       int
+}
+
+func newFakeS3() *fakeS3 {
+	return &fakeS3{objects: map[string]*fakeObject{}}
+}
+
+func (f *fakeS3) GetObject(in *s3.GetObjectInput) (*s3.GetObjectOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.getCalls++
+	obj, ok := f.objects[aws.StringValue(in.Key)]
+	if !ok {
+		return nil, awserr.New(s3.ErrCodeNoSuchKey, "no such key", nil)
+	}
+
+	lastModified := obj.lastModified
+	return &s3.GetObjectOutput{
+		Body:         ioutil.NopCloser(bytes.NewReader(obj.body)),
+		Metadata:     obj.metadata,
+		LastModified: &lastModified,
+	}, nil
+}
+
+func (f *fakeS3) PutObject(in *s3.PutObjectInput) (*s3.PutObjectOutput, error) {
+	var body []byte
+	if in.Body != nil {
+		body, _ = ioutil.ReadAll(in.Body)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.objects[aws.StringValue(in.Key)] = &fakeObject{body: body, metadata: in.Metadata, lastModified: time.Now()}
+
+	return &s3.PutObjectOutput{}, nil
+}
+
+func (f *fakeS3) DeleteObject(in *s3.DeleteObjectInput) (*s3.DeleteObjectOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.objects, aws.StringValue(in.Key))
+	return &s3.DeleteObjectOutput{}, nil
+}
+
+func (f *fakeS3) DeleteObjects(in *s3.DeleteObjectsInput) (*s3.DeleteObjectsOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, o := range in.Delete.Objects {
+		delete(f.objects, aws.StringValue(o.Key))
+	}
+	return &s3.DeleteObjectsOutput{}, nil
+}
+
+func (f *fakeS3) ListObjectsV2Pages(in *s3.ListObjectsV2Input, fn func(*s3.ListObjectsV2Output, bool) bool) error {
+	prefix := aws.StringValue(in.Prefix)
+
+	f.mu.Lock()
+	var keys []string
+	for key := range f.objects {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	f.mu.Unlock()
+
+	sort.Strings(keys)
+
+	contents := make([]*s3.Object, 0, len(keys))
+	for _, key := range keys {
+		contents = append(contents, &s3.Object{Key: aws.String(key)})
+	}
+
+	fn(&s3.ListObjectsV2Output{Contents: contents}, true)
+	return nil
+}
+
+func (f *fakeS3) HeadBucket(*s3.HeadBucketInput) (*s3.HeadBucketOutput, error) {
+	return &s3.HeadBucketOutput{}, nil
+}
+
+func (f *fakeS3) CreateBucket(*s3.CreateBucketInput) (*s3.CreateBucketOutput, error) {
+	return &s3.CreateBucketOutput{}, nil
+}
+
+func (f *fakeS3) GetBucketLifecycleConfiguration(*s3.GetBucketLifecycleConfigurationInput) (*s3.GetBucketLifecycleConfigurationOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if len(f.lifecycleRules) == 0 {
+		return nil, awserr.New("NoSuchLifecycleConfiguration", "no lifecycle configuration", nil)
+	}
+	return &s3.GetBucketLifecycleConfigurationOutput{Rules: f.lifecycleRules}, nil
+}
+
+func (f *fakeS3) PutBucketLifecycleConfiguration(in *s3.PutBucketLifecycleConfigurationInput) (*s3.PutBucketLifecycleConfigurationOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.lifecycleRules = in.LifecycleConfiguration.Rules
+	return &s3.PutBucketLifecycleConfigurationOutput{}, nil
+}
+
+// fakeUploader implements Uploader on top of fakeS3, for SetStream and the multipart Set path.
+type fakeUploader struct{ s3 *fakeS3 }
+
+func (u *fakeUploader) Upload(in *s3manager.UploadInput, _ ...func(*s3manager.Uploader)) (*s3manager.UploadOutput, error) {
+	var body []byte
+	if in.Body != nil {
+		body, _ = ioutil.ReadAll(in.Body)
+	}
+
+	_, err := u.s3.PutObject(&s3.PutObjectInput{
+		Bucket:      in.Bucket,
+		Key:         in.Key,
+		Body:        bytes.NewReader(body),
+		ContentType: in.ContentType,
+		Metadata:    in.Metadata,
+		Expires:     in.Expires,
+	})
+	return &s3manager.UploadOutput{}, err
+}
+
+// fakeDownloader implements Downloader; none of the tests in this package exercise it.
+type fakeDownloader struct{}
+
+func (d *fakeDownloader) Download(io.WriterAt, *s3.GetObjectInput, ...func(*s3manager.Downloader)) (int64, error) {
+	return 0, awserr.New("NotImplemented", "fakeDownloader does not support Download", nil)
+}
+
+// newTestBackend builds an S3Backend around a fresh fakeS3, applying opts on top of the defaults.
+func newTestBackend(opts ...Option) (*S3Backend, *fakeS3) {
+	fake := newFakeS3()
+	backend := NewS3BackendWithClient(fake, &fakeUploader{s3: fake}, &fakeDownloader{}, "prefix", "test-bucket", "test", opts...)
+	return backend, fake
+}
@@ -0,0 +1,182 @@
+package s3backend
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// defaultLifecycleMaxAgeDays is how long an object may live in the bucket before the S3 lifecycle
+// rule installed by NewS3Backend deletes it, as a backstop for entries the app never Purges.
+const defaultLifecycleMaxAgeDays = 30
+
+// maxDeleteObjectsBatch is the largest batch S3's DeleteObjects API accepts per call
+const maxDeleteObjectsBatch = 1000
+
+// configureLifecycleMu serializes configureLifecycle's read-modify-write of the bucket's lifecycle
+// configuration across every S3Backend in this process, so two backends on different keyPrefixes
+// constructed concurrently in the same process don't race and drop each other's rule. It can't
+// protect against the same race between separate processes - S3 has no compare-and-swap for this
+// API - so deployments provisioning several keyPrefixes on one bucket should still stagger startup
+// or provision the lifecycle configuration once out-of-band.
+var configureLifecycleMu sync.Mutex
+
+// WithLifecycleMaxAge overrides the number of days an object may live in the bucket before the
+// S3 lifecycle rule scoped to keyPrefix deletes it. A value <= 0 disables rule provisioning.
+func WithLifecycleMaxAge(days int) Option {
+	return func(b *S3Backend) {
+		b.lifecycleMaxAgeDays = days
+	}
+}
+
+// configureLifecycle installs a bucket lifecycle rule, scoped to keyPrefix, that expires objects
+// after lifecycleMaxAgeDays. This is a backstop against entries accumulating forever when callers
+// never Purge/Flush them; the app-level TTL is enforced on read by isExpired, from the
+// x-amz-meta-lifetime/x-amz-meta-gracetime metadata Set writes.
+//
+// PutBucketLifecycleConfiguration replaces the bucket's entire lifecycle configuration rather than
+// merging into it, so a second S3Backend/TieredS3Backend sharing the bucket under a different
+// keyPrefix would otherwise silently wipe out this rule (or vice versa). configureLifecycle reads
+// the existing configuration first and only replaces the rule carrying its own ID.
+func (b *S3Backend) configureLifecycle() error {
+	if b.lifecycleMaxAgeDays <= 0 {
+		return nil
+	}
+
+	rule := &s3.LifecycleRule{
+		ID:     aws.String(b.lifecycleRuleID()),
+		Status: aws.String(s3.ExpirationStatusEnabled),
+		Filter: &s3.LifecycleRuleFilter{
+			Prefix: aws.String(fmt.Sprintf("/%v", b.keyPrefix)),
+		},
+		Expiration: &s3.LifecycleExpiration{
+			Days: aws.Int64(int64(b.lifecycleMaxAgeDays)),
+		},
+	}
+
+	configureLifecycleMu.Lock()
+	defer configureLifecycleMu.Unlock()
+
+	rules, err := b.mergedLifecycleRules(rule)
+	if err != nil {
+		return err
+	}
+
+	_, err = b.s3.PutBucketLifecycleConfiguration(&s3.PutBucketLifecycleConfigurationInput{
+		Bucket: aws.String(b.bucketName),
+		LifecycleConfiguration: &s3.BucketLifecycleConfiguration{
+			Rules: rules,
+		},
+	})
+
+	return err
+}
+
+func (b *S3Backend) lifecycleRuleID() string {
+	return fmt.Sprintf("%v-expiration", b.keyPrefix)
+}
+
+// mergedLifecycleRules returns the bucket's current lifecycle rules with rule swapped in for the
+// rule sharing its ID (or appended, if none does), so other keyPrefixes' rules are preserved.
+// GetBucketLifecycleConfiguration errors with NoSuchLifecycleConfiguration on a bucket that has
+// never had one configured, which just means there's nothing yet to merge with.
+func (b *S3Backend) mergedLifecycleRules(rule *s3.LifecycleRule) ([]*s3.LifecycleRule, error) {
+	existing, err := b.s3.GetBucketLifecycleConfiguration(&s3.GetBucketLifecycleConfigurationInput{
+		Bucket: aws.String(b.bucketName),
+	})
+	if err != nil {
+		if awsErr, ok := err.(awserr.Error); ok && awsErr.Code() == "NoSuchLifecycleConfiguration" {
+			return []*s3.LifecycleRule{rule}, nil
+		}
+		return nil, err
+	}
+
+	rules := make([]*s3.LifecycleRule, 0, len(existing.Rules)+1)
+	replaced := false
+	for _, r := range existing.Rules {
+		if aws.StringValue(r.ID) == aws.StringValue(rule.ID) {
+			rules = append(rules, rule)
+			replaced = true
+			continue
+		}
+		rules = append(rules, r)
+	}
+	if !replaced {
+		rules = append(rules, rule)
+	}
+
+	return rules, nil
+}
+
+// isExpired reports whether an object is past its app-level TTL, based on the x-amz-meta-lifetime/
+// x-amz-meta-gracetime written by Set, without needing to decode the body.
+func (b *S3Backend) isExpired(lastModified *time.Time, metadata map[string]*string) bool {
+	if lastModified == nil {
+		return false
+	}
+
+	lifetime, err := time.ParseDuration(aws.StringValue(metadata[metaKeyLifetime]))
+	if err != nil {
+		return false
+	}
+
+	gracetime, err := time.ParseDuration(aws.StringValue(metadata[metaKeyGracetime]))
+	if err != nil {
+		return false
+	}
+
+	return time.Now().After(lastModified.Add(lifetime).Add(gracetime))
+}
+
+// deleteByPrefix paginates over every object under prefix and deletes it in batches of up to
+// maxDeleteObjectsBatch via DeleteObjects, instead of issuing one DeleteObject call per key.
+func (b *S3Backend) deleteByPrefix(prefix string) error {
+	var objects []*s3.ObjectIdentifier
+
+	err := b.s3.ListObjectsV2Pages(&s3.ListObjectsV2Input{
+		Bucket: aws.String(b.bucketName),
+		Prefix: aws.String(prefix),
+	}, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, s3Object := range page.Contents {
+			objects = append(objects, &s3.ObjectIdentifier{Key: s3Object.Key})
+		}
+		return true
+	})
+	if err != nil {
+		b.logger.WithField("category", "s3Backend").Error(fmt.Sprintf("Failed list for purge of prefix '%v': %v", prefix, err))
+		return err
+	}
+
+	if err := b.batchDeleteObjects(objects); err != nil {
+		b.logger.WithField("category", "s3Backend").Error(fmt.Sprintf("Failed batch DEL for prefix '%v': %v", prefix, err))
+		return err
+	}
+
+	return nil
+}
+
+// batchDeleteObjects issues DeleteObjects in chunks of up to maxDeleteObjectsBatch, the most S3 accepts per call.
+func (b *S3Backend) batchDeleteObjects(objects []*s3.ObjectIdentifier) error {
+	for len(objects) > 0 {
+		n := maxDeleteObjectsBatch
+		if n > len(objects) {
+			n = len(objects)
+		}
+
+		_, err := b.s3.DeleteObjects(&s3.DeleteObjectsInput{
+			Bucket: aws.String(b.bucketName),
+			Delete: &s3.Delete{Objects: objects[:n]},
+		})
+		if err != nil {
+			return err
+		}
+
+		objects = objects[n:]
+	}
+
+	return nil
+}
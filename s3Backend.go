@@ -11,19 +11,43 @@ import (
 	"flamingo.me/flamingo/v3/framework/flamingo"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/kms"
 	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+)
+
+const (
+	// defaultPartSize is the chunk size used by the multipart uploader/downloader, in bytes.
+	defaultPartSize int64 = 5 * 1024 * 1024
+	// defaultConcurrency is the number of parts uploaded/downloaded in parallel.
+	defaultConcurrency = 5
+	// defaultMultipartThreshold is the encoded entry size above which Set switches to the multipart uploader.
+	defaultMultipartThreshold int64 = 16 * 1024 * 1024
 )
 
 type (
 	// S3Backend instance representation
 	S3Backend struct {
 		cacheMetrics cache.CacheMetrics
-		s3           *s3.S3
+		s3           S3API
+		uploader     Uploader
+		downloader   Downloader
 		keyPrefix    string
 		bucketName   string
 		logger       flamingo.Logger
+		codec        Codec
+		kmsClient    *kms.KMS
+
+		encryption          EncryptionConfig
+		multipartThreshold  int64
+		lifecycleMaxAgeDays int
+		partSize            int64
+		concurrency         int
 	}
 
+	// Option configures an S3Backend at construction time
+	Option func(*S3Backend)
+
 	// S3CacheEntry representation
 	S3CacheEntry struct {
 		Meta s3CacheEntryMeta
@@ -33,6 +57,7 @@ type (
 	// s3CacheEntryMeta representation
 	s3CacheEntryMeta struct {
 		Lifetime, Gracetime time.Duration
+		Tags                []string
 	}
 )
 
@@ -40,8 +65,41 @@ func init() {
 	gob.Register(new(S3CacheEntry))
 }
 
+// WithPartSize overrides the default multipart chunk size used for streaming uploads/downloads.
+// It only has an effect on NewS3Backend, which builds its own uploader/downloader from this value;
+// callers of NewS3BackendWithClient configure their Uploader/Downloader directly.
+func WithPartSize(partSize int64) Option {
+	return func(b *S3Backend) {
+		b.partSize = partSize
+	}
+}
+
+// WithConcurrency overrides the default number of parts uploaded/downloaded in parallel. It only
+// has an effect on NewS3Backend; see WithPartSize.
+func WithConcurrency(concurrency int) Option {
+	return func(b *S3Backend) {
+		b.concurrency = concurrency
+	}
+}
+
+// WithMultipartThreshold overrides the encoded entry size above which Set switches to the multipart uploader
+func WithMultipartThreshold(threshold int64) Option {
+	return func(b *S3Backend) {
+		b.multipartThreshold = threshold
+	}
+}
+
+// WithCodec overrides the default GobCodec used to encode/decode entries written by this backend.
+// Entries are still readable regardless of which codec wrote them, since the codec identifier
+// travels with the object as x-amz-meta-codec.
+func WithCodec(codec Codec) Option {
+	return func(b *S3Backend) {
+		b.codec = codec
+	}
+}
+
 // NewS3Backend creates an S3Backend instance
-func NewS3Backend(s3Config *aws.Config, keyPrefix string, bucketName string, frontendName string) *S3Backend {
+func NewS3Backend(s3Config *aws.Config, keyPrefix string, bucketName string, frontendName string, opts ...Option) *S3Backend {
 	awsSession, _ := session.NewSession(s3Config)
 	s3Service := s3.New(awsSession)
 
@@ -49,53 +107,136 @@ func NewS3Backend(s3Config *aws.Config, keyPrefix string, bucketName string, fro
 		Bucket: aws.String(bucketName),
 	})
 
-	err := s3Service.WaitUntilBucketExists(&s3.HeadBucketInput{
-		Bucket: aws.String(bucketName),
-	})
-	if err != nil {
+	if err := waitUntilBucketExists(s3Service, bucketName); err != nil {
 		panic(err)
 	}
 
-	return &S3Backend{
-		cacheMetrics: cache.NewCacheMetrics("s3", frontendName),
-		keyPrefix:    keyPrefix,
-		bucketName:   bucketName,
-		logger:       flamingo.NullLogger{},
-		s3:           s3Service,
+	b := &S3Backend{
+		cacheMetrics:        cache.NewCacheMetrics("s3", frontendName),
+		keyPrefix:           keyPrefix,
+		bucketName:          bucketName,
+		logger:              flamingo.NullLogger{},
+		s3:                  s3Service,
+		codec:               GobCodec{},
+		multipartThreshold:  defaultMultipartThreshold,
+		lifecycleMaxAgeDays: defaultLifecycleMaxAgeDays,
+		partSize:            defaultPartSize,
+		concurrency:         defaultConcurrency,
+	}
+
+	for _, opt := range opts {
+		opt(b)
+	}
+
+	b.uploader = s3manager.NewUploader(awsSession, func(u *s3manager.Uploader) {
+		u.PartSize = b.partSize
+		u.Concurrency = b.concurrency
+	})
+	b.downloader = s3manager.NewDownloader(awsSession, func(d *s3manager.Downloader) {
+		d.PartSize = b.partSize
+		d.Concurrency = b.concurrency
+	})
+
+	if b.encryption.Mode == EncryptionClient {
+		b.kmsClient = kms.New(awsSession)
+	}
+
+	if err := b.configureLifecycle(); err != nil {
+		b.logger.WithField("category", "s3Backend").Error(fmt.Sprintf("Failed to configure bucket lifecycle: %v", err))
 	}
+
+	return b
 }
 
 // Get entry by tag
 func (b *S3Backend) Get(key string) (entry *cache.Entry, found bool) {
-	getObjectOut, err := b.s3.GetObject(&s3.GetObjectInput{
-		Bucket: aws.String(b.bucketName),
-		Key:    aws.String(fmt.Sprintf("/%v/%v", b.keyPrefix, key)),
+	entry, err := b.fetchEntry(key)
+	if err != nil {
+		if fe, ok := err.(*fetchError); ok {
+			b.cacheMetrics.countError(fe.reason)
+		} else {
+			b.cacheMetrics.countError(fmt.Sprintf("%v", err))
+		}
+		return nil, false
+	}
+
+	b.cacheMetrics.countHit()
+	return entry, true
+}
+
+// fetchError labels a fetchEntry failure with the reason Get reports to cacheMetrics.
+type fetchError struct {
+	reason string
+	err    error
+}
+
+func (e *fetchError) Error() string { return fmt.Sprintf("%v: %v", e.reason, e.err) }
+func (e *fetchError) Unwrap() error { return e.err }
+
+// getObject issues the GetObject call shared by every read path (fetchEntry, currentTags,
+// GetStream), so the key construction and SSE-customer-field wiring live in one place.
+func (b *S3Backend) getObject(key string) (*s3.GetObjectOutput, error) {
+	sseCAlgorithm, sseCKey, sseCKeyMD5 := b.getObjectSSEFields()
+
+	return b.s3.GetObject(&s3.GetObjectInput{
+		Bucket:               aws.String(b.bucketName),
+		Key:                  aws.String(fmt.Sprintf("/%v/%v", b.keyPrefix, key)),
+		SSECustomerAlgorithm: sseCAlgorithm,
+		SSECustomerKey:       sseCKey,
+		SSECustomerKeyMD5:    sseCKeyMD5,
 	})
+}
 
+// fetchEntry reads and decodes the entry stored under key, without touching cacheMetrics - Get is
+// the only caller that should count the result as an application-level hit or miss.
+func (b *S3Backend) fetchEntry(key string) (*cache.Entry, error) {
+	getObjectOut, err := b.getObject(key)
 	if err != nil {
-		b.cacheMetrics.countError(fmt.Sprintf("%v", err))
-		return nil, false
+		return nil, err
+	}
+
+	if b.isExpired(getObjectOut.LastModified, getObjectOut.Metadata) {
+		_ = getObjectOut.Body.Close()
+		return nil, &fetchError{reason: "Expired", err: fmt.Errorf("key %v is past its lifetime/gracetime", key)}
 	}
 
 	body, err := ioutil.ReadAll(getObjectOut.Body)
 	if err != nil {
-		b.cacheMetrics.countError(fmt.Sprintf("%v", err))
-		return nil, false
+		return nil, err
+	}
+
+	// SetStream writes the raw payload with Meta in object metadata, never codec-encoded or envelope-sealed
+	if aws.StringValue(getObjectOut.Metadata[metaKeyEncoding]) == metaEncodingRaw {
+		entry, err := b.entryFromMetadata(getObjectOut.Metadata)
+		if err != nil {
+			return nil, &fetchError{reason: "DecodeFailed", err: err}
+		}
+		entry.Data = body
+		return entry, nil
 	}
 
-	s3Entry, err := b.decodeEntry(body)
+	if b.encryption.Mode == EncryptionClient {
+		body, err = b.envelopeDecrypt(body, getObjectOut.Metadata)
+		if err != nil {
+			b.logger.WithField("category", "s3Backend").Error(fmt.Sprintf("Error decrypting content of key '%v': %v", key, err))
+			return nil, &fetchError{reason: "DecryptFailed", err: err}
+		}
+	}
+
+	codec := resolveCodec(aws.StringValue(getObjectOut.Metadata[metaKeyCodec]))
+	s3Entry, err := b.decodeEntry(codec, body)
 	if err != nil {
-		b.cacheMetrics.countError("DecodeFailed")
 		b.logger.WithField("category", "s3Backend").Error(fmt.Sprintf("Error decoding content of key '%v': %v", key, err))
-		return nil, false
+		return nil, &fetchError{reason: "DecodeFailed", err: err}
 	}
 
-	b.cacheMetrics.countHit()
-	return b.buildResult(s3Entry), true
+	return b.buildResult(s3Entry), nil
 }
 
 // Set an cache key
 func (b *S3Backend) Set(key string, entry *cache.Entry) error {
+	oldTags := b.currentTags(key)
+
 	s3Entry := b.buildEntry(entry)
 
 	buffer, err := b.encodeEntry(s3Entry)
@@ -105,22 +246,75 @@ func (b *S3Backend) Set(key string, entry *cache.Entry) error {
 		return err
 	}
 
-	_, err = b.s3.PutObject(&s3.PutObjectInput{
-		Bucket: aws.String(b.bucketName),
-		Key:    aws.String(fmt.Sprintf("/%v/%v", b.keyPrefix, key)),
-		Body:   bytes.NewReader(buffer.Bytes()),
-	})
+	metadata := map[string]*string{
+		metaKeyCodec:     aws.String(b.codec.Name()),
+		metaKeyLifetime:  aws.String(entry.Meta.Lifetime.String()),
+		metaKeyGracetime: aws.String(entry.Meta.Gracetime.String()),
+		metaKeyEncoding:  aws.String(metaEncodingGob),
+	}
+	expires := aws.Time(time.Now().Add(entry.Meta.Lifetime))
+
+	body := buffer.Bytes()
+	if b.encryption.Mode == EncryptionClient {
+		var envelopeMeta map[string]*string
+		body, envelopeMeta, err = b.envelopeEncrypt(body)
+		if err != nil {
+			b.cacheMetrics.countError("EncryptFailed")
+			b.logger.WithField("category", "s3Backend").Error(fmt.Sprintf("Error encrypting key %v: %v", key, err))
+			return err
+		}
+		for k, v := range envelopeMeta {
+			metadata[k] = v
+		}
+	}
+
+	sse, kmsKeyID, sseCAlgorithm, sseCKey, sseCKeyMD5 := b.putObjectSSEFields()
+
+	if int64(len(body)) > b.multipartThreshold {
+		_, err = b.uploader.Upload(&s3manager.UploadInput{
+			Bucket:               aws.String(b.bucketName),
+			Key:                  aws.String(fmt.Sprintf("/%v/%v", b.keyPrefix, key)),
+			Body:                 bytes.NewReader(body),
+			ContentType:          aws.String(b.codec.ContentType()),
+			Metadata:             metadata,
+			Expires:              expires,
+			ServerSideEncryption: sse,
+			SSEKMSKeyId:          kmsKeyID,
+			SSECustomerAlgorithm: sseCAlgorithm,
+			SSECustomerKey:       sseCKey,
+			SSECustomerKeyMD5:    sseCKeyMD5,
+		})
+	} else {
+		_, err = b.s3.PutObject(&s3.PutObjectInput{
+			Bucket:               aws.String(b.bucketName),
+			Key:                  aws.String(fmt.Sprintf("/%v/%v", b.keyPrefix, key)),
+			Body:                 bytes.NewReader(body),
+			ContentType:          aws.String(b.codec.ContentType()),
+			Metadata:             metadata,
+			Expires:              expires,
+			ServerSideEncryption: sse,
+			SSEKMSKeyId:          kmsKeyID,
+			SSECustomerAlgorithm: sseCAlgorithm,
+			SSECustomerKey:       sseCKey,
+			SSECustomerKeyMD5:    sseCKeyMD5,
+		})
+	}
 	if err != nil {
 		b.cacheMetrics.countError("SetFailed")
 		b.logger.WithField("category", "s3Backend").Error("Error setting key %v with timeout %v and buffer %v", key, int(entry.Meta.Gracetime.Seconds()), buffer)
 		return err
 	}
 
+	b.deleteTagMarkers(key, removedTags(oldTags, entry.Meta.Tags))
+	b.writeTagMarkers(key, entry.Meta.Tags)
+
 	return nil
 }
 
 // Purge an cache key
 func (b *S3Backend) Purge(key string) error {
+	b.deleteTagMarkers(key, b.currentTags(key))
+
 	_, err := b.s3.DeleteObject(&s3.DeleteObjectInput{
 		Bucket: aws.String(b.bucketName),
 		Key:    aws.String(fmt.Sprintf("/%v/%v", b.keyPrefix, key)),
@@ -135,67 +329,39 @@ func (b *S3Backend) Purge(key string) error {
 
 // Flush the whole cache
 func (b *S3Backend) Flush() error {
-	listObjectsOut, err := b.s3.ListObjectsV2(&s3.ListObjectsV2Input{
-		Bucket: aws.String(b.bucketName),
-		Prefix: aws.String(fmt.Sprintf("/%v", b.keyPrefix)),
-	})
-	if err != nil {
-		b.logger.WithField("category", "s3Backend").Error(fmt.Sprintf("Failed list for purge %v", err))
-		return err
-	}
-
-	for _, s3Object := range listObjectsOut.Contents {
-		_, err := b.s3.DeleteObject(&s3.DeleteObjectInput{
-			Bucket: aws.String(b.bucketName),
-			Key:    s3Object.Key,
-		})
-		if err != nil {
-			b.logger.WithField("category", "s3Backend").Error(fmt.Sprintf("Failed DEL for key '%v': %v", s3Object.Key, err))
-			return err
-		}
-	}
-
-	return nil
+	return b.deleteByPrefix(fmt.Sprintf("/%v", b.keyPrefix))
 }
 
 func (b *S3Backend) encodeEntry(entry *S3CacheEntry) (*bytes.Buffer, error) {
 	buffer := new(bytes.Buffer)
-	err := gob.NewEncoder(buffer).Encode(entry)
+	err := b.codec.Encode(buffer, entry)
 	if err != nil {
 		return nil, err
 	}
 	return buffer, nil
 }
 
-func (b *S3Backend) decodeEntry(content []byte) (*S3CacheEntry, error) {
-	buffer := bytes.NewBuffer(content)
-	decoder := gob.NewDecoder(buffer)
-	entry := new(S3CacheEntry)
-	err := decoder.Decode(&entry)
-	if err != nil {
-		return nil, err
-	}
-
-	return entry, err
+func (b *S3Backend) decodeEntry(codec Codec, content []byte) (*S3CacheEntry, error) {
+	return codec.Decode(bytes.NewBuffer(content))
 }
 
-// buildEntry removes unneeded Meta.Tags before encoding
 func (b *S3Backend) buildEntry(entry *cache.Entry) *S3CacheEntry {
 	return &S3CacheEntry{
 		Meta: s3CacheEntryMeta{
 			Lifetime:  entry.Meta.Lifetime,
 			Gracetime: entry.Meta.Gracetime,
+			Tags:      entry.Meta.Tags,
 		},
 		Data: entry.Data,
 	}
 }
 
-// buildResult removes unneeded Meta.Tags before encoding
 func (b *S3Backend) buildResult(entry *S3CacheEntry) *cache.Entry {
 	return &cache.Entry{
 		Meta: cache.Meta{
 			Lifetime:  entry.Meta.Lifetime,
 			Gracetime: entry.Meta.Gracetime,
+			Tags:      entry.Meta.Tags,
 		},
 		Data: entry.Data,
 	}
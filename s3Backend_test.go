@@ -0,0 +1,204 @@
+package s3backend
+
+import (
+	"encoding/gob"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	cache "flamingo.me/flamingo/v3/core/cache"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// payload is a non-primitive Data value used to demonstrate JSONCodec/MsgpackCodec's concrete-type-
+// loss limitation documented on Codec; see TestNonPrimitiveDataConcreteTypePerCodec.
+type payload struct {
+	Name  string
+	Count int
+}
+
+func init() {
+	gob.Register(payload{})
+}
+
+func TestSetGetRoundTripPerCodec(t *testing.T) {
+	codecs := []Codec{GobCodec{}, JSONCodec{}, MsgpackCodec{}}
+
+	for _, codec := range codecs {
+		codec := codec
+		t.Run(codec.Name(), func(t *testing.T) {
+			backend, _ := newTestBackend(WithCodec(codec))
+
+			entry := &cache.Entry{
+				Meta: cache.Meta{Lifetime: time.Minute, Gracetime: time.Second, Tags: []string{"a", "b"}},
+				Data: "hello",
+			}
+
+			if err := backend.Set("key1", entry); err != nil {
+				t.Fatalf("Set: %v", err)
+			}
+
+			got, found := backend.Get("key1")
+			if !found {
+				t.Fatalf("Get: key1 not found")
+			}
+			if got.Data != entry.Data {
+				t.Errorf("Data = %v, want %v", got.Data, entry.Data)
+			}
+			if got.Meta.Lifetime != entry.Meta.Lifetime || got.Meta.Gracetime != entry.Meta.Gracetime {
+				t.Errorf("Meta = %+v, want %+v", got.Meta, entry.Meta)
+			}
+			if !reflect.DeepEqual(got.Meta.Tags, entry.Meta.Tags) {
+				t.Errorf("Tags = %v, want %v", got.Meta.Tags, entry.Meta.Tags)
+			}
+		})
+	}
+}
+
+func TestPurgeTagsRemovesOnlyMatchingKeys(t *testing.T) {
+	backend, _ := newTestBackend()
+
+	mustSet := func(key string, tags []string) {
+		t.Helper()
+		if err := backend.Set(key, &cache.Entry{Meta: cache.Meta{Lifetime: time.Minute, Tags: tags}, Data: key}); err != nil {
+			t.Fatalf("Set(%v): %v", key, err)
+		}
+	}
+
+	mustSet("tagged", []string{"news"})
+	mustSet("untagged", nil)
+
+	if err := backend.PurgeTags([]string{"news"}); err != nil {
+		t.Fatalf("PurgeTags: %v", err)
+	}
+
+	if _, found := backend.Get("tagged"); found {
+		t.Errorf("Get(tagged) found entry after PurgeTags([news]), want purged")
+	}
+	if _, found := backend.Get("untagged"); !found {
+		t.Errorf("Get(untagged) not found after unrelated PurgeTags, want still present")
+	}
+}
+
+func TestSetRetiresStaleTagMarkersOnRetag(t *testing.T) {
+	backend, _ := newTestBackend()
+
+	set := func(tags []string) {
+		t.Helper()
+		if err := backend.Set("key1", &cache.Entry{Meta: cache.Meta{Lifetime: time.Minute, Tags: tags}, Data: "v"}); err != nil {
+			t.Fatalf("Set: %v", err)
+		}
+	}
+
+	set([]string{"old"})
+	set([]string{"new"})
+
+	if err := backend.PurgeTags([]string{"old"}); err != nil {
+		t.Fatalf("PurgeTags(old): %v", err)
+	}
+	if _, found := backend.Get("key1"); !found {
+		t.Errorf("Get(key1) not found after purging a tag it was retagged away from, want still present")
+	}
+
+	if err := backend.PurgeTags([]string{"new"}); err != nil {
+		t.Fatalf("PurgeTags(new): %v", err)
+	}
+	if _, found := backend.Get("key1"); found {
+		t.Errorf("Get(key1) found after purging its current tag, want purged")
+	}
+}
+
+func TestPurgeRetiresTagMarkers(t *testing.T) {
+	backend, fake := newTestBackend()
+
+	if err := backend.Set("key1", &cache.Entry{Meta: cache.Meta{Lifetime: time.Minute, Tags: []string{"news"}}, Data: "v"}); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	if err := backend.Purge("key1"); err != nil {
+		t.Fatalf("Purge: %v", err)
+	}
+
+	prefix := backend.tagMarkerPrefix("news")
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	for key := range fake.objects {
+		if strings.HasPrefix(key, prefix) {
+			t.Errorf("tag marker %v still present after Purge", key)
+		}
+	}
+}
+
+func TestConfigureLifecycleMergesOtherPrefixes(t *testing.T) {
+	fake := newFakeS3()
+
+	otherRule := &s3.LifecycleRule{
+		ID:     aws.String("other-prefix-expiration"),
+		Status: aws.String(s3.ExpirationStatusEnabled),
+	}
+	if _, err := fake.PutBucketLifecycleConfiguration(&s3.PutBucketLifecycleConfigurationInput{
+		LifecycleConfiguration: &s3.BucketLifecycleConfiguration{Rules: []*s3.LifecycleRule{otherRule}},
+	}); err != nil {
+		t.Fatalf("seeding other rule: %v", err)
+	}
+
+	NewS3BackendWithClient(fake, &fakeUploader{s3: fake}, &fakeDownloader{}, "prefix", "test-bucket", "test")
+
+	out, err := fake.GetBucketLifecycleConfiguration(&s3.GetBucketLifecycleConfigurationInput{})
+	if err != nil {
+		t.Fatalf("GetBucketLifecycleConfiguration: %v", err)
+	}
+
+	var sawOther, sawOwn bool
+	for _, rule := range out.Rules {
+		switch aws.StringValue(rule.ID) {
+		case "other-prefix-expiration":
+			sawOther = true
+		case "prefix-expiration":
+			sawOwn = true
+		}
+	}
+	if !sawOther {
+		t.Errorf("configureLifecycle dropped the other prefix's rule, rules = %+v", out.Rules)
+	}
+	if !sawOwn {
+		t.Errorf("configureLifecycle didn't install its own rule, rules = %+v", out.Rules)
+	}
+}
+
+// TestNonPrimitiveDataConcreteTypePerCodec documents, rather than guards against, the
+// concrete-type-loss limitation described on JSONCodec/MsgpackCodec: only GobCodec round-trips a
+// non-primitive Data value as its original concrete type.
+func TestNonPrimitiveDataConcreteTypePerCodec(t *testing.T) {
+	in := payload{Name: "widget", Count: 3}
+
+	backend, _ := newTestBackend(WithCodec(GobCodec{}))
+	if err := backend.Set("key1", &cache.Entry{Meta: cache.Meta{Lifetime: time.Minute}, Data: in}); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	got, found := backend.Get("key1")
+	if !found {
+		t.Fatalf("Get: key1 not found")
+	}
+	if out, ok := got.Data.(payload); !ok || out != in {
+		t.Errorf("GobCodec: Data = %#v, want %#v preserved as payload", got.Data, in)
+	}
+
+	for _, codec := range []Codec{JSONCodec{}, MsgpackCodec{}} {
+		codec := codec
+		t.Run(codec.Name(), func(t *testing.T) {
+			backend, _ := newTestBackend(WithCodec(codec))
+			if err := backend.Set("key1", &cache.Entry{Meta: cache.Meta{Lifetime: time.Minute}, Data: in}); err != nil {
+				t.Fatalf("Set: %v", err)
+			}
+			got, found := backend.Get("key1")
+			if !found {
+				t.Fatalf("Get: key1 not found")
+			}
+			if _, ok := got.Data.(payload); ok {
+				t.Errorf("%v: Data came back as payload, want it to have lost its concrete type per Codec's documented limitation", codec.Name())
+			}
+		})
+	}
+}
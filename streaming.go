@@ -0,0 +1,152 @@
+package s3backend
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	cache "flamingo.me/flamingo/v3/core/cache"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+)
+
+// metaEncoding values identify how the object body was written, so Get/GetStream know how to read it back
+const (
+	metaEncodingRaw = "raw"
+	metaEncodingGob = "gob"
+)
+
+// S3 user-metadata keys used by the streaming read/write path. AWS lower-cases and prefixes these
+// with "x-amz-meta-" on the wire, e.g. "Lifetime" becomes "x-amz-meta-lifetime".
+const (
+	metaKeyLifetime  = "Lifetime"
+	metaKeyGracetime = "Gracetime"
+	metaKeyEncoding  = "Encoding"
+	metaKeyTags      = "Tags"
+)
+
+// tagSeparator joins entry.Meta.Tags into the single string metaKeyTags stores; S3 user-metadata
+// values can't be multi-valued. Tags containing the separator are rejected by SetStream rather than
+// silently corrupting the list.
+const tagSeparator = ","
+
+func encodeStreamTags(tags []string) (string, error) {
+	for _, tag := range tags {
+		if strings.Contains(tag, tagSeparator) {
+			return "", fmt.Errorf("tag %q contains the streaming metadata separator %q", tag, tagSeparator)
+		}
+	}
+	return strings.Join(tags, tagSeparator), nil
+}
+
+func decodeStreamTags(value string) []string {
+	if value == "" {
+		return nil
+	}
+	return strings.Split(value, tagSeparator)
+}
+
+// SetStream writes r directly as the object body instead of gob-encoding it, storing Meta as S3
+// object user-metadata. This avoids buffering the full payload into a gob-wrapped interface{} and
+// lets []byte/io.Reader values be streamed straight through to the multipart uploader. Server-side
+// encryption (SSE-S3/KMS/C) is applied like any other Set; EncryptionClient is not applied here
+// since envelope-sealing requires the full plaintext in memory, defeating the point of streaming:
+// under EncryptionClient the body is uploaded as-is, without envelope encryption, and Set is the
+// path for entries that need client-side envelope encryption. entry.Meta.Tags is indexed the same
+// way as Set's, so PurgeTags reaches entries written through this path too.
+func (b *S3Backend) SetStream(key string, entry *cache.Entry, r io.Reader) error {
+	tags, err := encodeStreamTags(entry.Meta.Tags)
+	if err != nil {
+		b.cacheMetrics.countError("InvalidTags")
+		b.logger.WithField("category", "s3Backend").Error(fmt.Sprintf("Error streaming key %v: %v", key, err))
+		return err
+	}
+
+	oldTags := b.currentTags(key)
+
+	sse, kmsKeyID, sseCAlgorithm, sseCKey, sseCKeyMD5 := b.putObjectSSEFields()
+
+	_, err = b.uploader.Upload(&s3manager.UploadInput{
+		Bucket: aws.String(b.bucketName),
+		Key:    aws.String(fmt.Sprintf("/%v/%v", b.keyPrefix, key)),
+		Body:   r,
+		Metadata: map[string]*string{
+			metaKeyLifetime:  aws.String(entry.Meta.Lifetime.String()),
+			metaKeyGracetime: aws.String(entry.Meta.Gracetime.String()),
+			metaKeyEncoding:  aws.String(metaEncodingRaw),
+			metaKeyTags:      aws.String(tags),
+		},
+		ServerSideEncryption: sse,
+		SSEKMSKeyId:          kmsKeyID,
+		SSECustomerAlgorithm: sseCAlgorithm,
+		SSECustomerKey:       sseCKey,
+		SSECustomerKeyMD5:    sseCKeyMD5,
+	})
+	if err != nil {
+		b.cacheMetrics.countError("SetStreamFailed")
+		b.logger.WithField("category", "s3Backend").Error(fmt.Sprintf("Error streaming key %v: %v", key, err))
+		return err
+	}
+
+	b.deleteTagMarkers(key, removedTags(oldTags, entry.Meta.Tags))
+	b.writeTagMarkers(key, entry.Meta.Tags)
+
+	return nil
+}
+
+// GetStream returns the raw object body for key without decoding it, alongside the cache.Entry
+// reconstructed from object metadata. It reads the body directly off the GetObject response so the
+// payload is streamed to the caller rather than buffered in memory; entries written by Set/gob are
+// reported via the x-amz-meta-encoding header and rejected here rather than handed back undecoded.
+func (b *S3Backend) GetStream(key string) (io.ReadCloser, *cache.Entry, bool) {
+	getObjectOut, err := b.getObject(key)
+	if err != nil {
+		b.cacheMetrics.countError(fmt.Sprintf("%v", err))
+		return nil, nil, false
+	}
+
+	if b.isExpired(getObjectOut.LastModified, getObjectOut.Metadata) {
+		_ = getObjectOut.Body.Close()
+		b.cacheMetrics.countError("Expired")
+		return nil, nil, false
+	}
+
+	if encoding := aws.StringValue(getObjectOut.Metadata[metaKeyEncoding]); encoding != metaEncodingRaw {
+		_ = getObjectOut.Body.Close()
+		b.cacheMetrics.countError(fmt.Sprintf("WrongEncoding:%v", encoding))
+		b.logger.WithField("category", "s3Backend").Error(fmt.Sprintf("GetStream of key '%v' refused: stored with encoding %q, not %q", key, encoding, metaEncodingRaw))
+		return nil, nil, false
+	}
+
+	entry, err := b.entryFromMetadata(getObjectOut.Metadata)
+	if err != nil {
+		b.cacheMetrics.countError("DecodeFailed")
+		b.logger.WithField("category", "s3Backend").Error(fmt.Sprintf("Error decoding metadata of key '%v': %v", key, err))
+		_ = getObjectOut.Body.Close()
+		return nil, nil, false
+	}
+
+	b.cacheMetrics.countHit()
+	return getObjectOut.Body, entry, true
+}
+
+func (b *S3Backend) entryFromMetadata(metadata map[string]*string) (*cache.Entry, error) {
+	lifetime, err := time.ParseDuration(aws.StringValue(metadata[metaKeyLifetime]))
+	if err != nil {
+		return nil, err
+	}
+
+	gracetime, err := time.ParseDuration(aws.StringValue(metadata[metaKeyGracetime]))
+	if err != nil {
+		return nil, err
+	}
+
+	return &cache.Entry{
+		Meta: cache.Meta{
+			Lifetime:  lifetime,
+			Gracetime: gracetime,
+			Tags:      decodeStreamTags(aws.StringValue(metadata[metaKeyTags])),
+		},
+	}, nil
+}
@@ -0,0 +1,103 @@
+package s3backend
+
+import (
+	"bytes"
+	"io/ioutil"
+	"reflect"
+	"testing"
+	"time"
+
+	cache "flamingo.me/flamingo/v3/core/cache"
+)
+
+func TestSetStreamGetStreamRoundTrip(t *testing.T) {
+	backend, _ := newTestBackend()
+
+	entry := &cache.Entry{Meta: cache.Meta{Lifetime: time.Minute, Gracetime: time.Second, Tags: []string{"a", "b"}}}
+
+	if err := backend.SetStream("key1", entry, bytes.NewReader([]byte("hello"))); err != nil {
+		t.Fatalf("SetStream: %v", err)
+	}
+
+	body, got, found := backend.GetStream("key1")
+	if !found {
+		t.Fatalf("GetStream: key1 not found")
+	}
+	defer body.Close()
+
+	content, err := ioutil.ReadAll(body)
+	if err != nil {
+		t.Fatalf("reading body: %v", err)
+	}
+	if string(content) != "hello" {
+		t.Errorf("body = %q, want %q", content, "hello")
+	}
+	if got.Meta.Lifetime != entry.Meta.Lifetime || got.Meta.Gracetime != entry.Meta.Gracetime {
+		t.Errorf("Meta = %+v, want %+v", got.Meta, entry.Meta)
+	}
+	if !reflect.DeepEqual(got.Meta.Tags, entry.Meta.Tags) {
+		t.Errorf("Tags = %v, want %v", got.Meta.Tags, entry.Meta.Tags)
+	}
+}
+
+func TestSetStreamRetiresStaleTagMarkersOnRetag(t *testing.T) {
+	backend, fake := newTestBackend()
+
+	set := func(tags []string) {
+		t.Helper()
+		entry := &cache.Entry{Meta: cache.Meta{Lifetime: time.Minute, Tags: tags}}
+		if err := backend.SetStream("key1", entry, bytes.NewReader([]byte("v"))); err != nil {
+			t.Fatalf("SetStream: %v", err)
+		}
+	}
+
+	set([]string{"old"})
+	set([]string{"new"})
+
+	prefix := backend.tagMarkerPrefix("old")
+	fake.mu.Lock()
+	for key := range fake.objects {
+		if len(key) >= len(prefix) && key[:len(prefix)] == prefix {
+			fake.mu.Unlock()
+			t.Fatalf("tag marker %v still present after SetStream retagged key1 away from it", key)
+		}
+	}
+	fake.mu.Unlock()
+
+	if err := backend.PurgeTags([]string{"old"}); err != nil {
+		t.Fatalf("PurgeTags(old): %v", err)
+	}
+	if _, _, found := backend.GetStream("key1"); !found {
+		t.Errorf("GetStream(key1) not found after purging a tag it was retagged away from, want still present")
+	}
+
+	if err := backend.PurgeTags([]string{"new"}); err != nil {
+		t.Fatalf("PurgeTags(new): %v", err)
+	}
+	if _, _, found := backend.GetStream("key1"); found {
+		t.Errorf("GetStream(key1) found after purging its current tag, want purged")
+	}
+}
+
+// TestSetStreamRetiresStaleTagMarkersAfterSet covers Set and SetStream overwriting the same key
+// across each other: SetStream's oldTags lookup has to recover Tags from a Set-written (codec-
+// encoded) body just as readily as from a SetStream-written (raw, metadata-tagged) one.
+func TestSetStreamRetiresStaleTagMarkersAfterSet(t *testing.T) {
+	backend, _ := newTestBackend()
+
+	if err := backend.Set("key1", &cache.Entry{Meta: cache.Meta{Lifetime: time.Minute, Tags: []string{"old"}}, Data: "v"}); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+
+	entry := &cache.Entry{Meta: cache.Meta{Lifetime: time.Minute, Tags: []string{"new"}}}
+	if err := backend.SetStream("key1", entry, bytes.NewReader([]byte("v2"))); err != nil {
+		t.Fatalf("SetStream: %v", err)
+	}
+
+	if err := backend.PurgeTags([]string{"old"}); err != nil {
+		t.Fatalf("PurgeTags(old): %v", err)
+	}
+	if _, _, found := backend.GetStream("key1"); !found {
+		t.Errorf("GetStream(key1) not found after purging a tag it was retagged away from via Set->SetStream, want still present")
+	}
+}
@@ -0,0 +1,200 @@
+package s3backend
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// tagsRootPrefix is the common prefix under which all tag markers live, regardless of tag
+func (b *S3Backend) tagsRootPrefix() string {
+	return fmt.Sprintf("/%v/_tags/", b.keyPrefix)
+}
+
+// tagMarkerPrefix is where Set writes empty marker objects per (tag, key) pair, so PurgeTags can
+// list every key carrying a tag without scanning and decoding the whole primary prefix.
+func (b *S3Backend) tagMarkerPrefix(tag string) string {
+	return b.tagsRootPrefix() + tag + "/"
+}
+
+func (b *S3Backend) tagMarkerKey(tag, key string) string {
+	return b.tagMarkerPrefix(tag) + key
+}
+
+// writeTagMarkers writes an empty marker object for every tag in tags, so PurgeTags can later find
+// key without decoding every entry. Failures are logged rather than returned: the tag index is a
+// secondary structure and Rebuild exists to repair it after a crash mid-write.
+func (b *S3Backend) writeTagMarkers(key string, tags []string) {
+	for _, tag := range tags {
+		_, err := b.s3.PutObject(&s3.PutObjectInput{
+			Bucket: aws.String(b.bucketName),
+			Key:    aws.String(b.tagMarkerKey(tag, key)),
+		})
+		if err != nil {
+			b.logger.WithField("category", "s3Backend").Error(fmt.Sprintf("Failed to write tag marker for key %v, tag %v: %v", key, tag, err))
+		}
+	}
+}
+
+// deleteTagMarkers removes the marker objects for key under each of tags via batched DeleteObjects.
+// Like writeTagMarkers, failures are logged rather than returned: a stale marker just means Rebuild
+// has slightly more to clean up later, not a correctness break for the key being written/purged.
+func (b *S3Backend) deleteTagMarkers(key string, tags []string) {
+	if len(tags) == 0 {
+		return
+	}
+
+	objects := make([]*s3.ObjectIdentifier, 0, len(tags))
+	for _, tag := range tags {
+		objects = append(objects, &s3.ObjectIdentifier{Key: aws.String(b.tagMarkerKey(tag, key))})
+	}
+
+	if err := b.batchDeleteObjects(objects); err != nil {
+		b.logger.WithField("category", "s3Backend").Error(fmt.Sprintf("Failed to delete tag markers for key %v: %v", key, err))
+	}
+}
+
+// currentTags reads back the tags key currently carries, if any, so Set/SetStream/Purge can find
+// the markers an overwrite/delete needs to retire - whichever of Set or SetStream last wrote key.
+// A prior SetStream write carries its tags directly in the metaKeyTags metadata key; a prior Set
+// write has no such key, since Set folds Tags into the codec-encoded body instead, so this falls
+// back to decoding the body. It reads metadata/body directly rather than going through Get, so
+// this internal bookkeeping read isn't counted as an application-level cache hit/miss in
+// cacheMetrics; unlike fetchEntry it doesn't check the lifetime, so an expired entry's tags are still found.
+func (b *S3Backend) currentTags(key string) []string {
+	getObjectOut, err := b.getObject(key)
+	if err != nil {
+		return nil
+	}
+	defer getObjectOut.Body.Close()
+
+	if tags, ok := getObjectOut.Metadata[metaKeyTags]; ok {
+		return decodeStreamTags(aws.StringValue(tags))
+	}
+
+	body, err := ioutil.ReadAll(getObjectOut.Body)
+	if err != nil {
+		return nil
+	}
+
+	if b.encryption.Mode == EncryptionClient {
+		body, err = b.envelopeDecrypt(body, getObjectOut.Metadata)
+		if err != nil {
+			return nil
+		}
+	}
+
+	codec := resolveCodec(aws.StringValue(getObjectOut.Metadata[metaKeyCodec]))
+	entry, err := b.decodeEntry(codec, body)
+	if err != nil {
+		return nil
+	}
+	return entry.Meta.Tags
+}
+
+// removedTags returns the tags in oldTags that are absent from newTags.
+func removedTags(oldTags, newTags []string) []string {
+	keep := make(map[string]bool, len(newTags))
+	for _, tag := range newTags {
+		keep[tag] = true
+	}
+
+	var removed []string
+	for _, tag := range oldTags {
+		if !keep[tag] {
+			removed = append(removed, tag)
+		}
+	}
+	return removed
+}
+
+// PurgeTags invalidates every cache entry carrying any of tags, using the marker index written by
+// Set to avoid a full bucket scan. Both the marker and the underlying data object are removed for
+// each matching key, via batched DeleteObjects.
+func (b *S3Backend) PurgeTags(tags []string) error {
+	for _, tag := range tags {
+		if err := b.purgeTag(tag); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (b *S3Backend) purgeTag(tag string) error {
+	prefix := b.tagMarkerPrefix(tag)
+
+	var objects []*s3.ObjectIdentifier
+	err := b.s3.ListObjectsV2Pages(&s3.ListObjectsV2Input{
+		Bucket: aws.String(b.bucketName),
+		Prefix: aws.String(prefix),
+	}, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, marker := range page.Contents {
+			key := strings.TrimPrefix(aws.StringValue(marker.Key), prefix)
+			objects = append(objects,
+				&s3.ObjectIdentifier{Key: marker.Key},
+				&s3.ObjectIdentifier{Key: aws.String(fmt.Sprintf("/%v/%v", b.keyPrefix, key))},
+			)
+		}
+		return true
+	})
+	if err != nil {
+		b.logger.WithField("category", "s3Backend").Error(fmt.Sprintf("Failed list for PurgeTags of tag '%v': %v", tag, err))
+		return err
+	}
+
+	if err := b.batchDeleteObjects(objects); err != nil {
+		b.logger.WithField("category", "s3Backend").Error(fmt.Sprintf("Failed batch DEL for PurgeTags of tag '%v': %v", tag, err))
+		return err
+	}
+
+	return nil
+}
+
+// Rebuild reconstructs the tag index by scanning every entry under keyPrefix and re-writing its
+// tag markers, for recovery after a crash left Set's data write and marker write out of sync.
+func (b *S3Backend) Rebuild() error {
+	tagsPrefix := b.tagsRootPrefix()
+	dataPrefix := fmt.Sprintf("/%v/", b.keyPrefix)
+
+	var rebuildErr error
+	err := b.s3.ListObjectsV2Pages(&s3.ListObjectsV2Input{
+		Bucket: aws.String(b.bucketName),
+		Prefix: aws.String(dataPrefix),
+	}, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, s3Object := range page.Contents {
+			objectKey := aws.StringValue(s3Object.Key)
+			if strings.HasPrefix(objectKey, tagsPrefix) {
+				continue
+			}
+
+			key := strings.TrimPrefix(objectKey, dataPrefix)
+			if rebuildErr = b.rebuildEntry(key); rebuildErr != nil {
+				return false
+			}
+		}
+		return true
+	})
+	if err != nil {
+		b.logger.WithField("category", "s3Backend").Error(fmt.Sprintf("Failed list for Rebuild: %v", err))
+		return err
+	}
+
+	return rebuildErr
+}
+
+// rebuildEntry fetches key directly via fetchEntry rather than Get, so re-deriving markers for
+// every object under keyPrefix doesn't flood cacheMetrics with a hit/error per key.
+func (b *S3Backend) rebuildEntry(key string) error {
+	entry, err := b.fetchEntry(key)
+	if err != nil {
+		return nil
+	}
+
+	b.writeTagMarkers(key, entry.Meta.Tags)
+
+	return nil
+}
@@ -0,0 +1,173 @@
+package s3backend
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	cache "flamingo.me/flamingo/v3/core/cache"
+	lru "github.com/hashicorp/golang-lru"
+	"golang.org/x/sync/singleflight"
+)
+
+// defaultTieredEntrySizeEstimate is the byte cost charged against maxBytes for an entry whose
+// Data isn't a []byte, since an arbitrary interface{} can't be sized without encoding it.
+const defaultTieredEntrySizeEstimate = 256
+
+type (
+	// TieredS3Backend wraps an S3Backend with a bounded in-memory LRU write-through tier, so
+	// repeated Gets for hot keys avoid the ~30-100ms S3 round trip. Concurrent Gets for the same
+	// missing key are collapsed into a single S3 fetch via singleflight.
+	TieredS3Backend struct {
+		backend      *S3Backend
+		cacheMetrics cache.CacheMetrics
+		group        singleflight.Group
+
+		mu       sync.Mutex
+		lru      *lru.Cache
+		maxBytes int64
+		curBytes int64
+	}
+
+	tieredEntry struct {
+		entry    *cache.Entry
+		size     int64
+		cachedAt time.Time
+	}
+)
+
+// tieredEntryExpired reports whether a value stored in the LRU tier is past its app-level TTL,
+// the same Lifetime+Gracetime window isExpired enforces against S3's LastModified - measured here
+// against the time the entry was loaded into the LRU tier instead.
+func tieredEntryExpired(value tieredEntry) bool {
+	return time.Now().After(value.cachedAt.Add(value.entry.Meta.Lifetime).Add(value.entry.Meta.Gracetime))
+}
+
+// NewTieredS3Backend wraps backend with an LRU tier bounded by maxEntries and maxBytes; whichever
+// limit is hit first evicts the least recently used key.
+func NewTieredS3Backend(backend *S3Backend, maxEntries int, maxBytes int64, frontendName string) *TieredS3Backend {
+	t := &TieredS3Backend{
+		backend:      backend,
+		cacheMetrics: cache.NewCacheMetrics("s3-lru", frontendName),
+		maxBytes:     maxBytes,
+	}
+
+	t.lru, _ = lru.NewWithEvict(maxEntries, func(_ interface{}, value interface{}) {
+		t.curBytes -= value.(tieredEntry).size
+	})
+
+	return t
+}
+
+// Get returns entry from the LRU tier if present and not past its TTL, otherwise loads it from S3
+// - collapsing concurrent Gets for the same key into a single S3 request - and populates the LRU
+// tier on hit.
+func (t *TieredS3Backend) Get(key string) (entry *cache.Entry, found bool) {
+	t.mu.Lock()
+	if value, ok := t.lru.Get(key); ok {
+		if tieredEntryExpired(value.(tieredEntry)) {
+			t.lru.Remove(key)
+		} else {
+			t.mu.Unlock()
+			t.cacheMetrics.countHit()
+			return value.(tieredEntry).entry, true
+		}
+	}
+	t.mu.Unlock()
+
+	value, err, _ := t.group.Do(key, func() (interface{}, error) {
+		entry, found := t.backend.Get(key)
+		if !found {
+			return nil, fmt.Errorf("key %v not found", key)
+		}
+		return entry, nil
+	})
+	if err != nil {
+		t.cacheMetrics.countError("Miss")
+		return nil, false
+	}
+
+	entry = value.(*cache.Entry)
+	t.storeLocal(key, entry)
+
+	return entry, true
+}
+
+// Set writes through to both the LRU tier and the wrapped S3Backend
+func (t *TieredS3Backend) Set(key string, entry *cache.Entry) error {
+	if err := t.backend.Set(key, entry); err != nil {
+		return err
+	}
+
+	t.storeLocal(key, entry)
+
+	return nil
+}
+
+// Purge invalidates key in both tiers
+func (t *TieredS3Backend) Purge(key string) error {
+	t.mu.Lock()
+	t.lru.Remove(key)
+	t.mu.Unlock()
+
+	return t.backend.Purge(key)
+}
+
+// Flush invalidates both tiers entirely
+func (t *TieredS3Backend) Flush() error {
+	t.mu.Lock()
+	t.lru.Purge()
+	t.curBytes = 0
+	t.mu.Unlock()
+
+	return t.backend.Flush()
+}
+
+// PurgeTags invalidates every entry carrying any of tags in the wrapped S3Backend. The LRU tier
+// keeps no local index of which cached keys carry which tags, so it can't be selectively invalidated
+// here; the whole tier is cleared instead, to avoid serving stale tagged entries out of the LRU.
+func (t *TieredS3Backend) PurgeTags(tags []string) error {
+	t.mu.Lock()
+	t.lru.Purge()
+	t.curBytes = 0
+	t.mu.Unlock()
+
+	return t.backend.PurgeTags(tags)
+}
+
+// Rebuild passes through to the wrapped S3Backend's tag index rebuild. It doesn't touch the LRU
+// tier, which holds no tag index of its own.
+func (t *TieredS3Backend) Rebuild() error {
+	return t.backend.Rebuild()
+}
+
+// GetStream passes through to the wrapped S3Backend. Streamed bodies aren't buffered into
+// cache.Entry.Data, so the LRU tier - which stores decoded entries - can't cache them.
+func (t *TieredS3Backend) GetStream(key string) (io.ReadCloser, *cache.Entry, bool) {
+	return t.backend.GetStream(key)
+}
+
+// SetStream passes through to the wrapped S3Backend; see GetStream.
+func (t *TieredS3Backend) SetStream(key string, entry *cache.Entry, r io.Reader) error {
+	return t.backend.SetStream(key, entry, r)
+}
+
+func (t *TieredS3Backend) storeLocal(key string, entry *cache.Entry) {
+	size := int64(defaultTieredEntrySizeEstimate)
+	if data, ok := entry.Data.([]byte); ok {
+		size = int64(len(data))
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.lru.Add(key, tieredEntry{entry: entry, size: size, cachedAt: time.Now()})
+	t.curBytes += size
+
+	for t.maxBytes > 0 && t.curBytes > t.maxBytes {
+		if _, _, ok := t.lru.RemoveOldest(); !ok {
+			break
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package s3backend
+
+import (
+	"testing"
+	"time"
+
+	cache "flamingo.me/flamingo/v3/core/cache"
+)
+
+func TestTieredGetEvictsExpiredLRUEntry(t *testing.T) {
+	backend, _ := newTestBackend()
+	tiered := NewTieredS3Backend(backend, 10, 0, "test")
+
+	fresh := &cache.Entry{Meta: cache.Meta{Lifetime: time.Minute}, Data: "fresh"}
+	if err := backend.Set("key1", fresh); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+
+	stale := &cache.Entry{Meta: cache.Meta{Lifetime: time.Minute}, Data: "stale"}
+	tiered.mu.Lock()
+	tiered.lru.Add("key1", tieredEntry{entry: stale, size: defaultTieredEntrySizeEstimate, cachedAt: time.Now().Add(-2 * time.Minute)})
+	tiered.mu.Unlock()
+
+	got, found := tiered.Get("key1")
+	if !found {
+		t.Fatalf("Get: key1 not found")
+	}
+	if got.Data != "fresh" {
+		t.Errorf("Get returned stale LRU-cached entry %v past its TTL, want fallback to backend's %v", got.Data, fresh.Data)
+	}
+}
+
+func TestTieredGetServesUnexpiredLRUEntryWithoutBackendHit(t *testing.T) {
+	backend, fake := newTestBackend()
+	tiered := NewTieredS3Backend(backend, 10, 0, "test")
+
+	cached := &cache.Entry{Meta: cache.Meta{Lifetime: time.Minute}, Data: "cached"}
+	tiered.mu.Lock()
+	tiered.lru.Add("key1", tieredEntry{entry: cached, size: defaultTieredEntrySizeEstimate, cachedAt: time.Now()})
+	tiered.mu.Unlock()
+
+	got, found := tiered.Get("key1")
+	if !found {
+		t.Fatalf("Get: key1 not found")
+	}
+	if got.Data != "cached" {
+		t.Errorf("Get = %v, want %v served from the LRU tier", got.Data, cached.Data)
+	}
+	if fake.getCalls != 0 {
+		t.Errorf("Get hit S3 for an unexpired LRU entry, want served from the LRU tier only")
+	}
+}